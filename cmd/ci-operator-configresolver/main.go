@@ -1,16 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	prowConfig "k8s.io/test-infra/prow/config"
 	"k8s.io/test-infra/prow/interrupts"
@@ -29,38 +41,29 @@ const (
 )
 
 type options struct {
-	configPath   string
-	registryPath string
-	logLevel     string
-	address      string
-	gracePeriod  time.Duration
-	cycle        time.Duration
-	validateOnly bool
-	flatRegistry bool
-}
-
-type traceResponseWriter struct {
-	http.ResponseWriter
-	statusCode int
-	size       int
-}
-
-func (w *traceResponseWriter) WriteHeader(code int) {
-	w.statusCode = code
-	w.ResponseWriter.WriteHeader(code)
-}
-
-func (w *traceResponseWriter) Write(data []byte) (int, error) {
-	size, err := w.ResponseWriter.Write(data)
-	w.size += size
-	return size, err
+	configPath        string
+	registryPath      string
+	logLevel          string
+	address           string
+	gracePeriod       time.Duration
+	cycle             time.Duration
+	validateOnly      bool
+	flatRegistry      bool
+	tlsCertFile       string
+	tlsKeyFile        string
+	clientCAFile      string
+	requireClientCert bool
+	enableLifecycle   bool
 }
 
 var (
 	configresolverMetrics = struct {
-		httpRequestDuration *prometheus.HistogramVec
-		httpResponseSize    *prometheus.HistogramVec
-		errorRate           *prometheus.CounterVec
+		httpRequestDuration  *prometheus.HistogramVec
+		httpResponseSize     *prometheus.HistogramVec
+		httpRequestsInFlight *prometheus.GaugeVec
+		httpRequestsTotal    *prometheus.CounterVec
+		errorRate            *prometheus.CounterVec
+		bulkConfigsReturned  prometheus.Counter
 	}{
 		httpRequestDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -68,7 +71,7 @@ var (
 				Help:    "http request duration in seconds",
 				Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2},
 			},
-			[]string{"status", "path"},
+			[]string{"code", "path"},
 		),
 		httpResponseSize: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -76,7 +79,21 @@ var (
 				Help:    "http response size in bytes",
 				Buckets: []float64{256, 512, 1024, 2048, 4096, 6144, 8192, 10240, 12288},
 			},
-			[]string{"status", "path"},
+			[]string{"code", "path"},
+		),
+		httpRequestsInFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "configresolver_http_requests_in_flight",
+				Help: "number of http requests currently being served",
+			},
+			[]string{"path"},
+		),
+		httpRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "configresolver_http_requests_total",
+				Help: "number of http requests, by status code",
+			},
+			[]string{"code", "method", "path"},
 		),
 		errorRate: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -85,9 +102,30 @@ var (
 			},
 			[]string{"error"},
 		),
+		bulkConfigsReturned: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "configresolver_bulk_configs_returned",
+				Help: "number of resolved configs returned by /configs requests",
+			},
+		),
 	}
 )
 
+// instrument wraps h with the promhttp delegator-based metrics
+// (duration, response size, in-flight gauge, and request counter), all
+// labeled with the fixed path it is registered under. Using the promhttp
+// wrappers (instead of a hand-rolled ResponseWriter) correctly captures the
+// status code even when a handler hijacks or flushes the connection.
+func instrument(path string, h http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"path": path}
+	var handler http.Handler = h
+	handler = promhttp.InstrumentHandlerResponseSize(configresolverMetrics.httpResponseSize.MustCurryWith(labels), handler)
+	handler = promhttp.InstrumentHandlerCounter(configresolverMetrics.httpRequestsTotal.MustCurryWith(labels), handler)
+	handler = promhttp.InstrumentHandlerDuration(configresolverMetrics.httpRequestDuration.MustCurryWith(labels), handler)
+	handler = promhttp.InstrumentHandlerInFlight(configresolverMetrics.httpRequestsInFlight.With(labels), handler)
+	return handler
+}
+
 func gatherOptions() options {
 	o := options{}
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
@@ -99,6 +137,11 @@ func gatherOptions() options {
 	fs.DurationVar(&o.cycle, "cycle", time.Minute*2, "Cycle duration for config reload")
 	fs.BoolVar(&o.validateOnly, "validate-only", false, "Load the config and registry, validate them and exit.")
 	fs.BoolVar(&o.flatRegistry, "flat-registry", false, "Disable directory structure based registry validation")
+	fs.StringVar(&o.tlsCertFile, "tls-cert", "", "Path to TLS certificate to serve the API over HTTPS")
+	fs.StringVar(&o.tlsKeyFile, "tls-key", "", "Path to the key matching --tls-cert")
+	fs.StringVar(&o.clientCAFile, "client-ca-file", "", "Path to a CA bundle used to verify client certificates")
+	fs.BoolVar(&o.requireClientCert, "require-client-cert", false, "Require and verify a client certificate signed by --client-ca-file")
+	fs.BoolVar(&o.enableLifecycle, "web.enable-lifecycle", false, "Enable the /-/reload admin endpoint")
 	fs.Parse(os.Args[1:])
 	return o
 }
@@ -128,6 +171,12 @@ func validateOptions(o options) error {
 	if o.validateOnly && o.flatRegistry {
 		return errors.New("--validate-only and --flat-registry flags cannot be set simultaneously")
 	}
+	if (o.tlsCertFile == "") != (o.tlsKeyFile == "") {
+		return errors.New("--tls-cert and --tls-key must be set together")
+	}
+	if o.requireClientCert && o.clientCAFile == "" {
+		return errors.New("--require-client-cert requires --client-ca-file")
+	}
 	return nil
 }
 
@@ -142,19 +191,89 @@ func missingQuery(w http.ResponseWriter, field string) {
 	fmt.Fprintf(w, "%s query missing or incorrect", field)
 }
 
-func handleWithMetrics(h http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t := time.Now()
-		// Initialize the status to 200 in case WriteHeader is not called
-		trw := &traceResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		h(trw, r)
-		latency := time.Since(t)
-		labels := prometheus.Labels{"status": strconv.Itoa(trw.statusCode), "path": r.URL.EscapedPath()}
-		configresolverMetrics.httpRequestDuration.With(labels).Observe(latency.Seconds())
-		configresolverMetrics.httpResponseSize.With(labels).Observe(float64(trw.size))
-		log.Debugf("Response Time: %.6f", latency.Seconds())
-		log.Debugf("Response Size: %.0f", float64(trw.size))
-	})
+// computeETag returns a strong ETag derived from parts, joined so that
+// e.g. ("ab", "c") and ("a", "bc") never collide.
+func computeETag(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%s\x00", part)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// checkNotModified sets the ETag and Cache-Control headers for a
+// conditional-GET-capable response and, if the request's If-None-Match
+// matches etag, writes a 304 and reports that the caller has nothing more
+// to do.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// compressionThreshold is the minimum response size, in bytes, below which
+// compress skips encoding: the gzip/flate framing overhead isn't worth it
+// for small responses.
+const compressionThreshold = 1400
+
+var gzipWriterPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(ioutil.Discard) }}
+
+// bufferedResponseWriter captures a handler's body so compress can decide,
+// once the full response is known, whether it's worth encoding.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	buf        bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(code int) { b.statusCode = code }
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) { return b.buf.Write(p) }
+
+// compress wraps next with gzip/deflate content negotiation. Small bodies
+// and clients that don't advertise support for either encoding are passed
+// through unchanged.
+func compress(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept-Encoding")
+		supportsGzip := strings.Contains(accept, "gzip")
+		supportsDeflate := strings.Contains(accept, "deflate")
+		if !supportsGzip && !supportsDeflate {
+			next(w, r)
+			return
+		}
+
+		brw := &bufferedResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(brw, r)
+		body := brw.buf.Bytes()
+
+		w.Header().Set("Vary", "Accept-Encoding")
+		if len(body) < compressionThreshold {
+			w.WriteHeader(brw.statusCode)
+			w.Write(body)
+			return
+		}
+
+		if supportsGzip {
+			gz := gzipWriterPool.Get().(*gzip.Writer)
+			defer gzipWriterPool.Put(gz)
+			gz.Reset(w)
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(brw.statusCode)
+			gz.Write(body)
+			gz.Close()
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "deflate")
+		w.WriteHeader(brw.statusCode)
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		fw.Write(body)
+		fw.Close()
+	}
 }
 
 func genericHandler() http.HandlerFunc {
@@ -210,6 +329,19 @@ func resolveConfig(configAgent load.ConfigAgent, registryAgent load.RegistryAgen
 			log.WithError(err).Warning("failed to resolve config with registry")
 			return
 		}
+
+		// Only compute and serve an ETag once the config has actually
+		// resolved, so an error response (e.g. unknown org/repo) can't be
+		// masked as a 304 by a later If-None-Match request.
+		etag := computeETag(
+			fmt.Sprintf("%d", configAgent.GetGeneration()),
+			fmt.Sprintf("%d", registryAgent.GetGeneration()),
+			org, repo, branch, variant,
+		)
+		if checkNotModified(w, r, etag) {
+			return
+		}
+
 		jsonConfig, err := json.MarshalIndent(config, "", "  ")
 		if err != nil {
 			recordError("failed to marshal config")
@@ -223,24 +355,178 @@ func resolveConfig(configAgent load.ConfigAgent, registryAgent load.RegistryAgen
 	}
 }
 
+// resolveAllConfigs streams every config known to configAgent, resolved
+// through registryAgent, as a JSON array, or as newline-delimited JSON when
+// the client sends "Accept: application/x-ndjson". Optional org/repo query
+// parameters narrow the set of configs returned.
+func resolveAllConfigs(configAgent load.ConfigAgent, registryAgent load.RegistryAgent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		org := r.URL.Query().Get(orgQuery)
+		repo := r.URL.Query().Get(repoQuery)
+		ndjson := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+
+		if ndjson {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		returned := 0
+		if !ndjson {
+			w.Write([]byte("["))
+		}
+		for _, info := range configAgent.GetAllConfigInfos() {
+			if org != "" && info.Org != org {
+				continue
+			}
+			if repo != "" && info.Repo != repo {
+				continue
+			}
+			resolved, err := configAgent.GetConfig(info)
+			if err != nil {
+				recordError("config not found")
+				log.WithError(err).Warning("failed to get config")
+				continue
+			}
+			resolved, err = registryAgent.ResolveConfig(resolved)
+			if err != nil {
+				recordError("failed to resolve config with registry")
+				log.WithError(err).Warning("failed to resolve config with registry")
+				continue
+			}
+			// Encode into a buffer first so a marshal failure can't leave a
+			// dangling separator behind from a comma written for an item
+			// that never made it into the response.
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(resolved); err != nil {
+				recordError("failed to marshal config")
+				log.WithError(err).Error("failed to marshal config to JSON")
+				continue
+			}
+			if !ndjson && returned > 0 {
+				w.Write([]byte(","))
+			}
+			w.Write(buf.Bytes())
+			returned++
+		}
+		if !ndjson {
+			w.Write([]byte("]"))
+		}
+		configresolverMetrics.bulkConfigsReturned.Add(float64(returned))
+	}
+}
+
+// readiness tracks whether the resolver's dependencies are in a servable
+// state. It starts ready (the agents are loaded synchronously before main
+// registers any handler) and flips to not-ready after a failed /-/reload
+// until a later one succeeds, so probes stop sending traffic to a resolver serving
+// off of a config or registry it failed to refresh.
+type readiness struct {
+	ready int32
+}
+
+func newReadiness() *readiness {
+	r := &readiness{}
+	r.set(true)
+	return r
+}
+
+func (r *readiness) set(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&r.ready, v)
+}
+
+func (r *readiness) isReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+// reloadHandler triggers an immediate, out-of-band reload of both agents,
+// following the Prometheus web server's POST-only /-/reload convention. A
+// failed reload marks the resolver not-ready until a later reload succeeds.
+func reloadHandler(configAgent load.ConfigAgent, registryAgent load.RegistryAgent, ready *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		if err := configAgent.Reload(); err != nil {
+			ready.set(false)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to reload config: %v", err)
+			return
+		}
+		if err := registryAgent.Reload(); err != nil {
+			ready.set(false)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to reload registry: %v", err)
+			return
+		}
+		ready.set(true)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// healthyHandler and readyHandler give the same well-known probe surface as
+// pjutil.NewHealth() under this server's own mux, for operators that prefer
+// a single port to scrape both application and health endpoints from.
+// readyHandler reports the same readiness state health.ServeReady is wired
+// to report on its own port.
+func healthyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func readyHandler(ready *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ready.isReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 func getConfigGeneration(agent load.ConfigAgent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		generation := agent.GetGeneration()
+		if checkNotModified(w, r, computeETag(fmt.Sprintf("%d", generation))) {
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "%d", agent.GetGeneration())
+		fmt.Fprintf(w, "%d", generation)
 	}
 }
 
 func getRegistryGeneration(agent load.RegistryAgent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		generation := agent.GetGeneration()
+		if checkNotModified(w, r, computeETag(fmt.Sprintf("%d", generation))) {
+			return
+		}
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "%d", agent.GetGeneration())
+		fmt.Fprintf(w, "%d", generation)
 	}
 }
 
 func init() {
+	// The default registry already registers a GoCollector and
+	// ProcessCollector on its own; registering another pair here would
+	// panic with AlreadyRegisteredError.
 	prometheus.MustRegister(configresolverMetrics.httpRequestDuration)
 	prometheus.MustRegister(configresolverMetrics.httpResponseSize)
+	prometheus.MustRegister(configresolverMetrics.httpRequestsInFlight)
+	prometheus.MustRegister(configresolverMetrics.httpRequestsTotal)
 	prometheus.MustRegister(configresolverMetrics.errorRate)
+	prometheus.MustRegister(configresolverMetrics.bulkConfigsReturned)
 }
 
 func main() {
@@ -252,6 +538,7 @@ func main() {
 	level, _ := log.ParseLevel(o.logLevel)
 	log.SetLevel(level)
 	health := pjutil.NewHealth()
+	ready := newReadiness()
 	metrics.ExposeMetrics("ci-operator-configresolver", prowConfig.PushGateway{})
 
 	configAgent, err := load.NewConfigAgent(o.configPath, o.cycle, configresolverMetrics.errorRate)
@@ -269,11 +556,159 @@ func main() {
 	}
 
 	// add handler func for incorrect paths as well; can help with identifying errors/404s caused by incorrect paths
-	http.HandleFunc("/", handleWithMetrics(genericHandler()))
-	http.HandleFunc("/config", handleWithMetrics(resolveConfig(configAgent, registryAgent)))
-	http.HandleFunc("/configGeneration", handleWithMetrics(getConfigGeneration(configAgent)))
-	http.HandleFunc("/registryGeneration", handleWithMetrics(getRegistryGeneration(registryAgent)))
-	interrupts.ListenAndServe(&http.Server{Addr: o.address}, o.gracePeriod)
-	health.ServeReady()
+	http.Handle("/", instrument("/", genericHandler()))
+	http.Handle("/config", instrument("/config", compress(resolveConfig(configAgent, registryAgent))))
+	http.Handle("/configGeneration", instrument("/configGeneration", getConfigGeneration(configAgent)))
+	http.Handle("/registryGeneration", instrument("/registryGeneration", getRegistryGeneration(registryAgent)))
+	http.Handle("/configs", instrument("/configs", resolveAllConfigs(configAgent, registryAgent)))
+	http.Handle("/-/healthy", instrument("/-/healthy", healthyHandler()))
+	http.Handle("/-/ready", instrument("/-/ready", readyHandler(ready)))
+	if o.enableLifecycle {
+		http.Handle("/-/reload", instrument("/-/reload", reloadHandler(configAgent, registryAgent, ready)))
+	}
+	http.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{Addr: o.address}
+	if o.tlsCertFile != "" {
+		tlsConfig, err := newTLSConfig(o)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		// interrupts.ListenAndServe only calls server.ListenAndServe(), which
+		// ignores server.TLSConfig, so the TLS listener has to be started
+		// directly; it's registered with interrupts itself so
+		// WaitForGracefulShutdown below still drains it on shutdown.
+		interrupts.OnInterrupt(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), o.gracePeriod)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				log.WithError(err).Error("failed to gracefully shut down TLS server")
+			}
+		})
+		interrupts.Run(func(ctx context.Context) {
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("TLS server exited unexpectedly")
+			}
+		})
+	} else {
+		interrupts.ListenAndServe(server, o.gracePeriod)
+	}
+	health.ServeHealthy()
+	health.ServeReady(ready.isReady)
 	interrupts.WaitForGracefulShutdown()
 }
+
+// newTLSConfig builds a tls.Config that serves o.tlsCertFile/o.tlsKeyFile and,
+// if o.clientCAFile is set, verifies client certificates against it. Both the
+// server certificate and the client CA bundle are reloaded every o.cycle so
+// that rotated secrets are picked up without a restart.
+func newTLSConfig(o options) (*tls.Config, error) {
+	certs, err := newCertReloader(o.tlsCertFile, o.tlsKeyFile, o.cycle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load --tls-cert/--tls-key: %v", err)
+	}
+	tlsConfig := &tls.Config{GetCertificate: certs.GetCertificate}
+	if o.clientCAFile != "" {
+		ca, err := newCAReloader(o.clientCAFile, o.cycle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --client-ca-file: %v", err)
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if o.requireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := tlsConfig.Clone()
+			cfg.ClientCAs = ca.pool()
+			return cfg, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// certReloader serves the latest on-disk TLS certificate/key pair, reloading
+// it from disk every cycle so rotated secrets don't require a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string, cycle time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(cycle) {
+			if err := r.reload(); err != nil {
+				log.WithError(err).Error("failed to reload TLS certificate")
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// caReloader serves the latest client CA bundle parsed from disk, reloaded
+// every cycle.
+type caReloader struct {
+	caFile string
+
+	mu       sync.RWMutex
+	certPool *x509.CertPool
+}
+
+func newCAReloader(caFile string, cycle time.Duration) (*caReloader, error) {
+	r := &caReloader{caFile: caFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(cycle) {
+			if err := r.reload(); err != nil {
+				log.WithError(err).Error("failed to reload client CA bundle")
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *caReloader) reload() error {
+	raw, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return fmt.Errorf("no certificates found in %s", r.caFile)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certPool = pool
+	return nil
+}
+
+func (r *caReloader) pool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certPool
+}
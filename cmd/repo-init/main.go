@@ -0,0 +1,923 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+	"k8s.io/test-infra/prow/plugins"
+
+	"github.com/openshift/ci-tools/pkg/api"
+	ciopconfig "github.com/openshift/ci-tools/pkg/config"
+)
+
+const (
+	orgTemplate          = "openshift"
+	tideRepoTemplate     = "openshift/ci-tools"
+	bugzillaRepoTemplate = "openshift/origin"
+	pluginRepoTemplate   = "openshift/origin"
+)
+
+// test describes a simple container test step to onboard alongside the
+// generated ci-operator config.
+type test struct {
+	As      string
+	Command string
+	From    string
+}
+
+// e2eTest describes a cluster e2e test step, run against the repo's own
+// installer-src configuration rather than a vendored release payload.
+type e2eTest struct {
+	As      string
+	Command string
+	Profile string
+}
+
+// initConfig carries everything needed to onboard a new repo into Prow and
+// ci-operator.
+type initConfig struct {
+	Org                   string
+	Repo                  string
+	Branch                string
+	CanonicalGoRepository string
+	GoVersion             string
+	// Runtime selects a non-Go build root by name, e.g. "nodejs:14" or
+	// "python:3.9". It is mutually exclusive with GoVersion-derived
+	// defaults; leave empty to onboard a Go repo.
+	Runtime string
+	// BuildRootRef, if set, pins the build root to an arbitrary image and
+	// takes precedence over both Runtime and GoVersion.
+	BuildRootRef          *api.ImageStreamTagReference
+	BuildCommands         string
+	TestBuildCommands     string
+	Promotes              bool
+	PromotesWithOpenShift bool
+	NeedsOS               bool
+	NeedsBase             bool
+	Tests                 []test
+	CustomE2E             []e2eTest
+	// Branches fans a single onboarding out across multiple release
+	// branches, e.g. master plus release-4.x. If empty, generateCIOperatorConfig
+	// builds a single config from the fields above instead.
+	Branches []BranchSpec
+	// Migrate switches the CLI and KRM function from onboarding a new repo
+	// to rewriting the Prow, plugin, and ci-operator configs already on
+	// disk (or already present as ResourceList items) into their canonical
+	// shape via the migrate* functions.
+	Migrate bool
+}
+
+// BranchSpec is one release branch's worth of ci-operator config, generated
+// alongside its siblings by a single generateCIOperatorConfig call.
+type BranchSpec struct {
+	Branch                string
+	Promotes              bool
+	PromotesWithOpenShift bool
+	PromotionNamespace    string
+	PromotionName         string
+	GoVersion             string
+	Tests                 []test
+	CustomE2E             []e2eTest
+}
+
+type options struct {
+	initConfig
+
+	promotionNamespace string
+	promotionName      string
+	prowConfigPath     string
+	pluginConfigPath   string
+	outputConfigPath   string
+	krmFunction        bool
+	dryRun             bool
+}
+
+func gatherOptions() options {
+	o := options{}
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&o.Org, "org", "", "Organization for the repo being onboarded")
+	fs.StringVar(&o.Repo, "repo", "", "Name of the repo being onboarded")
+	fs.StringVar(&o.Branch, "branch", "master", "Branch being onboarded")
+	fs.StringVar(&o.CanonicalGoRepository, "canonical-go-repository", "", "Import path to vendor the repo under, if it differs from org/repo")
+	fs.StringVar(&o.GoVersion, "go-version", "1.13", "Go version to build with")
+	fs.StringVar(&o.Runtime, "runtime", "", "Non-Go build root to use, e.g. nodejs:14 (mutually exclusive with --go-version)")
+	fs.StringVar(&o.BuildCommands, "build-commands", "go build ./...", "Commands used to build binaries")
+	fs.StringVar(&o.TestBuildCommands, "test-build-commands", "go build -tags testrunmain ./...", "Commands used to build test binaries")
+	fs.BoolVar(&o.Promotes, "promotes", false, "Repo publishes its built images to an ImageStream")
+	fs.BoolVar(&o.PromotesWithOpenShift, "promotes-with-openshift", false, "Repo is part of the OpenShift release payload")
+	fs.BoolVar(&o.NeedsOS, "needs-os", false, "Repo needs the centos base image")
+	fs.BoolVar(&o.NeedsBase, "needs-base", false, "Repo needs the OCP base image")
+	fs.StringVar(&o.promotionNamespace, "promotion-namespace", "", "Namespace the repo promotes its images into")
+	fs.StringVar(&o.promotionName, "promotion-name", "", "ImageStream name the repo promotes its images into")
+	fs.StringVar(&o.prowConfigPath, "prow-config", "", "Path to the Prow config to add the new repo to")
+	fs.StringVar(&o.pluginConfigPath, "plugin-config", "", "Path to the Prow plugin config to add the new repo to")
+	fs.StringVar(&o.outputConfigPath, "output", "", "Path to write the generated ci-operator config to")
+	fs.BoolVar(&o.krmFunction, "krm-function", false, "Run as a KRM function: read a ResourceList from stdin and write the mutated ResourceList to stdout")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Print the Prow and plugin config plans instead of writing them")
+	fs.BoolVar(&o.Migrate, "migrate", false, "Rewrite the Prow/plugin/ci-operator configs at --prow-config/--plugin-config/--output into their canonical shape instead of onboarding a new repo")
+	fs.Parse(os.Args[1:])
+	return o
+}
+
+func validateOptions(o options) error {
+	if o.krmFunction {
+		return nil
+	}
+	if o.Migrate {
+		if o.prowConfigPath == "" && o.pluginConfigPath == "" && o.outputConfigPath == "" {
+			return fmt.Errorf("--migrate requires at least one of --prow-config, --plugin-config, or --output")
+		}
+		return nil
+	}
+	if o.Org == "" || o.Repo == "" {
+		return fmt.Errorf("--org and --repo are required")
+	}
+	if o.Runtime != "" && o.BuildRootRef != nil {
+		return fmt.Errorf("--runtime and a custom build root are mutually exclusive")
+	}
+	if o.outputConfigPath == "" {
+		return fmt.Errorf("--output is required")
+	}
+	return nil
+}
+
+// editProwConfig adds config.Org/config.Repo to Tide once: to the query that
+// already onboards the repo's promotion template, or a no-op if the repo is
+// already present in any query.
+func editProwConfig(prowConfig *prowconfig.Config, config initConfig) {
+	planned, _ := planProwConfig(prowConfig, config)
+	*prowConfig = *planned
+}
+
+// planProwConfig computes the Tide query change editProwConfig would make
+// for config.Org/config.Repo, without mutating orig, and returns a copy of
+// orig with that change applied alongside the Changes describing it (for a
+// --dry-run mode, in the style of the migrate* functions below). Calling it
+// again with the returned config is idempotent: the repo is now already
+// present in a query, so no further Changes come back.
+func planProwConfig(orig *prowconfig.Config, config initConfig) (*prowconfig.Config, []Change) {
+	migrated := *orig
+	queries := make(prowconfig.TideQueries, len(orig.Tide.Queries))
+	copy(queries, orig.Tide.Queries)
+	migrated.Tide.Queries = queries
+
+	repo := fmt.Sprintf("%s/%s", config.Org, config.Repo)
+	for _, query := range queries {
+		for _, r := range query.Repos {
+			if r == repo {
+				return &migrated, nil
+			}
+		}
+	}
+
+	marker := tideRepoTemplate
+	if config.PromotesWithOpenShift {
+		marker = bugzillaRepoTemplate
+	}
+	for i := range queries {
+		for _, r := range queries[i].Repos {
+			if r == marker {
+				queries[i].Repos = append(append([]string{}, queries[i].Repos...), repo)
+				return &migrated, []Change{{
+					Field:       "tide.queries",
+					Description: fmt.Sprintf("added %s to the query currently covering %s", repo, marker),
+				}}
+			}
+		}
+	}
+	return &migrated, nil
+}
+
+// editPluginConfig onboards config.Org/config.Repo into the plugin config,
+// inheriting the openshift template org's plugins plus the template repo's
+// (or, if config.Org already has its own entry, just the template repo's
+// plugins), inheriting the template org's external plugins unless config.Org
+// has its own, and always wiring up approve and lgtm for the new repo.
+func editPluginConfig(pluginConfig *plugins.Configuration, config initConfig) {
+	planned, _ := planPluginConfig(pluginConfig, config)
+	*pluginConfig = *planned
+}
+
+// planPluginConfig computes the plugin config changes editPluginConfig would
+// make for config.Org/config.Repo, without mutating orig, and returns a copy
+// of orig with those changes applied alongside the Changes describing them.
+// Calling it again with the returned config is idempotent: the repo already
+// has plugins, approve, and lgtm entries, so no further Changes come back.
+func planPluginConfig(orig *plugins.Configuration, config initConfig) (*plugins.Configuration, []Change) {
+	migrated := *orig
+	var changes []Change
+	repo := fmt.Sprintf("%s/%s", config.Org, config.Repo)
+
+	if _, exists := orig.Plugins[repo]; !exists {
+		var merged []string
+		if _, hasOrg := orig.Plugins[config.Org]; !hasOrg {
+			merged = append(merged, orig.Plugins[orgTemplate]...)
+		}
+		merged = append(merged, orig.Plugins[pluginRepoTemplate]...)
+		if len(merged) != 0 {
+			pluginsMap := map[string][]string{}
+			for k, v := range orig.Plugins {
+				pluginsMap[k] = v
+			}
+			pluginsMap[repo] = merged
+			migrated.Plugins = pluginsMap
+			changes = append(changes, Change{
+				Field:       "plugins",
+				Description: fmt.Sprintf("onboarded %s with plugins %v", repo, merged),
+			})
+		}
+	}
+
+	_, orgHasExternal := orig.ExternalPlugins[config.Org]
+	if _, exists := orig.ExternalPlugins[repo]; !exists && !orgHasExternal {
+		if template, ok := orig.ExternalPlugins[orgTemplate]; ok {
+			externalPlugins := map[string][]plugins.ExternalPlugin{}
+			for k, v := range orig.ExternalPlugins {
+				externalPlugins[k] = v
+			}
+			externalPlugins[repo] = template
+			migrated.ExternalPlugins = externalPlugins
+			changes = append(changes, Change{
+				Field:       "external_plugins",
+				Description: fmt.Sprintf("onboarded %s with external plugins %v", repo, template),
+			})
+		}
+	}
+
+	hasApprove := false
+	for _, approve := range orig.Approve {
+		for _, r := range approve.Repos {
+			if r == repo {
+				hasApprove = true
+			}
+		}
+	}
+	if !hasApprove {
+		requireSelfApproval := false
+		approve := plugins.Approve{
+			Repos:               []string{repo},
+			RequireSelfApproval: &requireSelfApproval,
+			LgtmActsAsApprove:   false,
+		}
+		migrated.Approve = append(append([]plugins.Approve{}, orig.Approve...), approve)
+		changes = append(changes, Change{Field: "approve", Description: fmt.Sprintf("added %s", repo)})
+	}
+
+	hasLgtm := false
+	for _, lgtm := range orig.Lgtm {
+		for _, r := range lgtm.Repos {
+			if r == repo {
+				hasLgtm = true
+			}
+		}
+	}
+	if !hasLgtm {
+		lgtm := plugins.Lgtm{Repos: []string{repo}, ReviewActsAsLgtm: true}
+		migrated.Lgtm = append(append([]plugins.Lgtm{}, orig.Lgtm...), lgtm)
+		changes = append(changes, Change{Field: "lgtm", Description: fmt.Sprintf("added %s", repo)})
+	}
+
+	return &migrated, changes
+}
+
+// buildRootFor resolves the build root image for config: a fully custom ref
+// takes precedence, then a named non-Go runtime, falling back to the
+// openshift/release golang image for Go repos.
+func buildRootFor(config initConfig) *api.BuildRootImageConfiguration {
+	if config.BuildRootRef != nil {
+		return &api.BuildRootImageConfiguration{ImageStreamTagReference: config.BuildRootRef}
+	}
+	if config.Runtime != "" {
+		name, tag := config.Runtime, "latest"
+		if idx := strings.Index(config.Runtime, ":"); idx != -1 {
+			name, tag = config.Runtime[:idx], config.Runtime[idx+1:]
+		}
+		return &api.BuildRootImageConfiguration{
+			ImageStreamTagReference: &api.ImageStreamTagReference{
+				Namespace: "openshift",
+				Name:      "release",
+				Tag:       fmt.Sprintf("%s-%s", name, tag),
+			},
+		}
+	}
+	return &api.BuildRootImageConfiguration{
+		ImageStreamTagReference: &api.ImageStreamTagReference{
+			Namespace: "openshift",
+			Name:      "release",
+			Tag:       fmt.Sprintf("golang-%s", config.GoVersion),
+		},
+	}
+}
+
+// generateCIOperatorConfig builds the ci-operator config(s) for a newly
+// onboarded repo, one per entry in config.Branches, or a single config built
+// from config's top-level Branch/Promotes/GoVersion/Tests fields if Branches
+// is empty. editProwConfig and editPluginConfig are unaffected by Branches:
+// they key off config.Org/config.Repo alone, so the org/repo entry they add
+// is shared across every branch and never duplicated.
+func generateCIOperatorConfig(config initConfig, originConfig *api.PromotionConfiguration) []ciopconfig.DataWithInfo {
+	branches := config.Branches
+	if len(branches) == 0 {
+		branches = []BranchSpec{{
+			Branch:                config.Branch,
+			Promotes:              config.Promotes,
+			PromotesWithOpenShift: config.PromotesWithOpenShift,
+			GoVersion:             config.GoVersion,
+			Tests:                 config.Tests,
+			CustomE2E:             config.CustomE2E,
+		}}
+	}
+
+	results := make([]ciopconfig.DataWithInfo, 0, len(branches))
+	for _, branch := range branches {
+		branchConfig := config
+		branchConfig.Branch = branch.Branch
+		branchConfig.Promotes = branch.Promotes
+		branchConfig.PromotesWithOpenShift = branch.PromotesWithOpenShift
+		branchConfig.GoVersion = branch.GoVersion
+		branchConfig.Tests = branch.Tests
+		branchConfig.CustomE2E = branch.CustomE2E
+
+		branchOrigin := originConfig
+		if branch.PromotionNamespace != "" || branch.PromotionName != "" {
+			branchOrigin = &api.PromotionConfiguration{Namespace: branch.PromotionNamespace, Name: branch.PromotionName}
+		}
+
+		results = append(results, generateSingleCIOperatorConfig(branchConfig, branchOrigin))
+	}
+	return results
+}
+
+// generateSingleCIOperatorConfig builds the ci-operator config for one
+// branch of a repo. Go-specific fields (CanonicalGoRepository and the binary
+// build commands) are only populated for the default Go build root; repos
+// onboarded via Runtime or a custom BuildRootRef skip them, as those build
+// roots don't produce Go binaries via `go build`.
+func generateSingleCIOperatorConfig(config initConfig, originConfig *api.PromotionConfiguration) ciopconfig.DataWithInfo {
+	generated := api.ReleaseBuildConfiguration{
+		InputConfiguration: api.InputConfiguration{
+			BuildRootImage: buildRootFor(config),
+		},
+		Tests: []api.TestStepConfiguration{},
+		Resources: map[string]api.ResourceRequirements{"*": {
+			Limits:   map[string]string{"memory": "4Gi"},
+			Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+		}},
+	}
+
+	if config.Runtime == "" && config.BuildRootRef == nil {
+		generated.BinaryBuildCommands = config.BuildCommands
+		generated.TestBinaryBuildCommands = config.TestBuildCommands
+		generated.CanonicalGoRepository = &config.CanonicalGoRepository
+	}
+
+	if config.Promotes {
+		if originConfig == nil {
+			// The KRM path (runKRMFunction) only sets originConfig from an
+			// existing ReleaseBuildConfiguration item; for a brand-new repo
+			// with no such item yet, fall back to an empty configuration
+			// rather than panicking.
+			originConfig = &api.PromotionConfiguration{}
+		}
+		generated.PromotionConfiguration = originConfig
+		generated.ReleaseTagConfiguration = &api.ReleaseTagConfiguration{
+			Namespace: originConfig.Namespace,
+			Name:      originConfig.Name,
+		}
+	}
+
+	if config.NeedsBase || config.NeedsOS {
+		generated.BaseImages = map[string]api.ImageStreamTagReference{}
+		if config.NeedsBase {
+			generated.BaseImages["base"] = api.ImageStreamTagReference{Namespace: "ocp", Name: "4.3", Tag: "base"}
+		}
+		if config.NeedsOS {
+			generated.BaseImages["os"] = api.ImageStreamTagReference{Namespace: "openshift", Name: "centos", Tag: "7"}
+		}
+	}
+
+	if config.PromotesWithOpenShift {
+		generated.Tests = append(generated.Tests, api.TestStepConfiguration{
+			As:       "e2e-aws",
+			Commands: "TEST_SUITE=openshift/conformance/parallel run-tests",
+			OpenshiftInstallerClusterTestConfiguration: &api.OpenshiftInstallerClusterTestConfiguration{
+				ClusterTestConfiguration: api.ClusterTestConfiguration{ClusterProfile: api.ClusterProfileAWS},
+			},
+		})
+	}
+
+	for _, t := range config.Tests {
+		generated.Tests = append(generated.Tests, api.TestStepConfiguration{
+			As:                         t.As,
+			Commands:                   t.Command,
+			ContainerTestConfiguration: &api.ContainerTestConfiguration{From: t.From},
+		})
+	}
+
+	for _, e := range config.CustomE2E {
+		generated.Tests = append(generated.Tests, api.TestStepConfiguration{
+			As:       e.As,
+			Commands: e.Command,
+			OpenshiftInstallerSrcClusterTestConfiguration: &api.OpenshiftInstallerSrcClusterTestConfiguration{
+				ClusterTestConfiguration: api.ClusterTestConfiguration{ClusterProfile: api.ClusterProfile(e.Profile)},
+			},
+		})
+	}
+
+	return ciopconfig.DataWithInfo{
+		Configuration: generated,
+		Info: ciopconfig.Info{
+			Org:    config.Org,
+			Repo:   config.Repo,
+			Branch: config.Branch,
+		},
+	}
+}
+
+// Change records one rewrite a migrate* function made, for presentation in
+// a review diff before the result is written back to disk.
+type Change struct {
+	Field       string
+	Description string
+}
+
+// formatChanges renders changes as a human-readable diff for --dry-run
+// output, one line per Change, or "no changes" if there aren't any.
+func formatChanges(changes []Change) string {
+	if len(changes) == 0 {
+		return "no changes\n"
+	}
+	var b strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&b, "%s: %s\n", change.Field, change.Description)
+	}
+	return b.String()
+}
+
+// migrateCIOperatorConfig rewrites an already-onboarded ci-operator config
+// into its canonical shape: inline container test stanzas become
+// step-registry references, the deprecated cluster-profile-only installer
+// configuration becomes a literal multi-stage test, and a missing default
+// Resources entry is filled in. It never touches tests that are already in
+// a canonical shape.
+func migrateCIOperatorConfig(existing ciopconfig.DataWithInfo) (ciopconfig.DataWithInfo, []Change, error) {
+	var changes []Change
+	migrated := existing
+	cfg := migrated.Configuration
+
+	if cfg.Resources == nil {
+		cfg.Resources = map[string]api.ResourceRequirements{"*": {
+			Limits:   map[string]string{"memory": "4Gi"},
+			Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+		}}
+		changes = append(changes, Change{Field: "resources", Description: "filled in missing default resource requirements"})
+	}
+
+	tests := make([]api.TestStepConfiguration, len(cfg.Tests))
+	copy(tests, cfg.Tests)
+	for i, t := range tests {
+		switch {
+		case t.ContainerTestConfiguration != nil:
+			ref := t.As
+			tests[i] = api.TestStepConfiguration{
+				As: t.As,
+				MultiStageTestConfiguration: &api.MultiStageTestConfiguration{
+					Test: []api.TestStep{{Reference: &ref}},
+				},
+			}
+			changes = append(changes, Change{
+				Field:       fmt.Sprintf("tests[%s]", t.As),
+				Description: fmt.Sprintf("converted inline container test to step registry reference %q", ref),
+			})
+		case t.OpenshiftInstallerClusterTestConfiguration != nil:
+			profile := t.OpenshiftInstallerClusterTestConfiguration.ClusterTestConfiguration.ClusterProfile
+			tests[i] = api.TestStepConfiguration{
+				As: t.As,
+				MultiStageTestConfigurationLiteral: &api.MultiStageTestConfigurationLiteral{
+					ClusterProfile: profile,
+				},
+			}
+			changes = append(changes, Change{
+				Field:       fmt.Sprintf("tests[%s]", t.As),
+				Description: "converted deprecated OpenshiftInstallerClusterTestConfiguration to a literal multi-stage test",
+			})
+		}
+	}
+	cfg.Tests = tests
+
+	migrated.Configuration = cfg
+	return migrated, changes, nil
+}
+
+// migrateProwConfig rewrites a Prow config into its canonical shape:
+// de-duplicating repos within a single Tide query, which is the shape
+// dead/renamed repo entries tend to leave behind, then dropping any query
+// left with no repos at all, which is dead weight Tide never matches
+// against. It leaves well-formed queries untouched.
+func migrateProwConfig(existing *prowconfig.Config) (*prowconfig.Config, []Change, error) {
+	var changes []Change
+	migrated := *existing
+	queries := make(prowconfig.TideQueries, 0, len(existing.Tide.Queries))
+	for _, query := range existing.Tide.Queries {
+		seen := map[string]bool{}
+		var deduped []string
+		for _, repo := range query.Repos {
+			if seen[repo] {
+				changes = append(changes, Change{
+					Field:       "tide.queries",
+					Description: fmt.Sprintf("pruned duplicate repo %q from Tide query", repo),
+				})
+				continue
+			}
+			seen[repo] = true
+			deduped = append(deduped, repo)
+		}
+		query.Repos = deduped
+		if len(query.Repos) == 0 {
+			changes = append(changes, Change{
+				Field:       "tide.queries",
+				Description: "dropped Tide query left with no repos",
+			})
+			continue
+		}
+		queries = append(queries, query)
+	}
+	migrated.Tide.Queries = queries
+	return &migrated, changes, nil
+}
+
+// migratePluginConfig rewrites a plugin config into its canonical shape,
+// pruning the same kind of duplicate repo entries migrateProwConfig prunes
+// from Tide queries.
+func migratePluginConfig(existing *plugins.Configuration) (*plugins.Configuration, []Change, error) {
+	var changes []Change
+	migrated := *existing
+	approvals := make([]plugins.Approve, len(existing.Approve))
+	copy(approvals, existing.Approve)
+	for i, approve := range approvals {
+		seen := map[string]bool{}
+		var deduped []string
+		for _, repo := range approve.Repos {
+			if seen[repo] {
+				changes = append(changes, Change{
+					Field:       "approve.repos",
+					Description: fmt.Sprintf("pruned duplicate repo %q from approve config", repo),
+				})
+				continue
+			}
+			seen[repo] = true
+			deduped = append(deduped, repo)
+		}
+		approvals[i].Repos = deduped
+	}
+	migrated.Approve = approvals
+	return &migrated, changes, nil
+}
+
+// migrateOnDisk runs the migrate* functions over whichever of
+// --prow-config/--plugin-config/--output are set, the --migrate counterpart
+// to the onboarding path in main(). Each config is migrated independently;
+// --dry-run prints the changes instead of writing them back.
+func migrateOnDisk(o options) {
+	if o.prowConfigPath != "" {
+		prowConfig, err := loadProwConfig(o.prowConfigPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load prow config: %v\n", err)
+			os.Exit(1)
+		}
+		migrated, changes, err := migrateProwConfig(prowConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate prow config: %v\n", err)
+			os.Exit(1)
+		}
+		if o.dryRun {
+			fmt.Printf("prow config:\n%s", formatChanges(changes))
+		} else if err := writeYAML(o.prowConfigPath, migrated); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write prow config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.pluginConfigPath != "" {
+		pluginConfig, err := loadPluginConfig(o.pluginConfigPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load plugin config: %v\n", err)
+			os.Exit(1)
+		}
+		migrated, changes, err := migratePluginConfig(pluginConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate plugin config: %v\n", err)
+			os.Exit(1)
+		}
+		if o.dryRun {
+			fmt.Printf("plugin config:\n%s", formatChanges(changes))
+		} else if err := writeYAML(o.pluginConfigPath, migrated); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write plugin config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.outputConfigPath != "" {
+		existing, err := loadCIOperatorConfig(o.outputConfigPath, o.initConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load ci-operator config: %v\n", err)
+			os.Exit(1)
+		}
+		migrated, changes, err := migrateCIOperatorConfig(existing)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate ci-operator config: %v\n", err)
+			os.Exit(1)
+		}
+		if o.dryRun {
+			fmt.Printf("ci-operator config:\n%s", formatChanges(changes))
+		} else if err := writeYAML(o.outputConfigPath, migrated.Configuration); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write ci-operator config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func loadProwConfig(path string) (*prowconfig.Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &prowconfig.Config{}
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadPluginConfig(path string) (*plugins.Configuration, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &plugins.Configuration{}
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadCIOperatorConfig(path string, config initConfig) (ciopconfig.DataWithInfo, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ciopconfig.DataWithInfo{}, err
+	}
+	c := api.ReleaseBuildConfiguration{}
+	if err := yaml.Unmarshal(raw, &c); err != nil {
+		return ciopconfig.DataWithInfo{}, err
+	}
+	return ciopconfig.DataWithInfo{
+		Configuration: c,
+		Info: ciopconfig.Info{
+			Org:    config.Org,
+			Repo:   config.Repo,
+			Branch: config.Branch,
+		},
+	}, nil
+}
+
+func writeYAML(path string, v interface{}) error {
+	raw, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+// resourceListItem is one entry of a KRM ResourceList's items; it is kept
+// as a generic map so unrecognized resources round-trip untouched.
+type resourceListItem = map[string]interface{}
+
+// krmFunctionConfig is the functionConfig entry of a KRM ResourceList,
+// carrying the initConfig to apply as its spec.
+type krmFunctionConfig struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Spec       initConfig `json:"spec"`
+}
+
+// resourceList is the subset of the KRM ResourceList schema this function
+// cares about: https://github.com/kubernetes-sigs/kustomize/blob/master/cmd/config/docs/api-conventions/functions-spec.md
+type resourceList struct {
+	APIVersion     string             `json:"apiVersion"`
+	Kind           string             `json:"kind"`
+	Items          []resourceListItem `json:"items"`
+	FunctionConfig *krmFunctionConfig `json:"functionConfig,omitempty"`
+}
+
+func decodeItem(item resourceListItem, kind string, out interface{}) (bool, error) {
+	if item["kind"] != kind {
+		return false, nil
+	}
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw, out)
+}
+
+func encodeItem(apiVersion, kind string, v interface{}) (resourceListItem, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	item := resourceListItem{}
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return nil, err
+	}
+	item["apiVersion"] = apiVersion
+	item["kind"] = kind
+	return item, nil
+}
+
+// runKRMFunction is the KRM entry point. With spec.migrate unset it applies
+// editProwConfig, editPluginConfig, and generateCIOperatorConfig to the
+// Config, Configuration, and ReleaseBuildConfiguration items of the
+// ResourceList read from r; with spec.migrate set it instead applies the
+// migrateProwConfig/migratePluginConfig/migrateCIOperatorConfig rewrites to
+// those same items. Either way the mutated ResourceList is written to w. It
+// exists so onboarding (or a bulk migration pass) can be composed into a
+// declarative kustomize/kpt rendering pipeline; the flag-based CLI in main()
+// is a thin wrapper reading files instead of a ResourceList.
+func runKRMFunction(r io.Reader, w io.Writer) error {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read ResourceList: %w", err)
+	}
+	list := resourceList{}
+	if err := yaml.Unmarshal(raw, &list); err != nil {
+		return fmt.Errorf("failed to parse ResourceList: %w", err)
+	}
+	if list.FunctionConfig == nil {
+		return fmt.Errorf("functionConfig is required")
+	}
+	config := list.FunctionConfig.Spec
+
+	var originConfig *api.PromotionConfiguration
+	haveCIOperatorConfig := false
+	for i, item := range list.Items {
+		prowConfig := &prowconfig.Config{}
+		if ok, err := decodeItem(item, "Config", prowConfig); err != nil {
+			return err
+		} else if ok {
+			if config.Migrate {
+				migrated, _, err := migrateProwConfig(prowConfig)
+				if err != nil {
+					return fmt.Errorf("failed to migrate prow config: %w", err)
+				}
+				prowConfig = migrated
+			} else {
+				editProwConfig(prowConfig, config)
+			}
+			if list.Items[i], err = encodeItem("prow.k8s.io/v1", "Config", prowConfig); err != nil {
+				return err
+			}
+			continue
+		}
+
+		pluginConfig := &plugins.Configuration{}
+		if ok, err := decodeItem(item, "Configuration", pluginConfig); err != nil {
+			return err
+		} else if ok {
+			if config.Migrate {
+				migrated, _, err := migratePluginConfig(pluginConfig)
+				if err != nil {
+					return fmt.Errorf("failed to migrate plugin config: %w", err)
+				}
+				pluginConfig = migrated
+			} else {
+				editPluginConfig(pluginConfig, config)
+			}
+			if list.Items[i], err = encodeItem("plugins.prow.k8s.io/v1", "Configuration", pluginConfig); err != nil {
+				return err
+			}
+			continue
+		}
+
+		releaseBuildConfig := &api.ReleaseBuildConfiguration{}
+		if ok, err := decodeItem(item, "ReleaseBuildConfiguration", releaseBuildConfig); err != nil {
+			return err
+		} else if ok {
+			haveCIOperatorConfig = true
+			if config.Migrate {
+				migrated, _, err := migrateCIOperatorConfig(ciopconfig.DataWithInfo{Configuration: *releaseBuildConfig})
+				if err != nil {
+					return fmt.Errorf("failed to migrate ci-operator config: %w", err)
+				}
+				releaseBuildConfig = &migrated.Configuration
+			} else {
+				originConfig = releaseBuildConfig.PromotionConfiguration
+			}
+			if list.Items[i], err = encodeItem("ci-operator.openshift.io/v1", "ReleaseBuildConfiguration", releaseBuildConfig); err != nil {
+				return err
+			}
+			continue
+		}
+	}
+
+	if !haveCIOperatorConfig && !config.Migrate {
+		for _, generated := range generateCIOperatorConfig(config, originConfig) {
+			item, err := encodeItem("ci-operator.openshift.io/v1", "ReleaseBuildConfiguration", generated.Configuration)
+			if err != nil {
+				return err
+			}
+			list.Items = append(list.Items, item)
+		}
+	}
+
+	out, err := yaml.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ResourceList: %w", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func main() {
+	o := gatherOptions()
+	if err := validateOptions(o); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid options: %v\n", err)
+		os.Exit(1)
+	}
+
+	if o.krmFunction {
+		if err := runKRMFunction(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "KRM function failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if o.Migrate {
+		migrateOnDisk(o)
+		return
+	}
+
+	if o.prowConfigPath != "" {
+		prowConfig, err := loadProwConfig(o.prowConfigPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load prow config: %v\n", err)
+			os.Exit(1)
+		}
+		planned, changes := planProwConfig(prowConfig, o.initConfig)
+		if o.dryRun {
+			fmt.Printf("prow config:\n%s", formatChanges(changes))
+		} else if err := writeYAML(o.prowConfigPath, planned); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write prow config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.pluginConfigPath != "" {
+		pluginConfig, err := loadPluginConfig(o.pluginConfigPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load plugin config: %v\n", err)
+			os.Exit(1)
+		}
+		planned, changes := planPluginConfig(pluginConfig, o.initConfig)
+		if o.dryRun {
+			fmt.Printf("plugin config:\n%s", formatChanges(changes))
+		} else if err := writeYAML(o.pluginConfigPath, planned); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write plugin config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ciopConfigs := generateCIOperatorConfig(o.initConfig, &api.PromotionConfiguration{
+		Namespace: o.promotionNamespace,
+		Name:      o.promotionName,
+	})
+	for _, ciopConfig := range ciopConfigs {
+		path := o.outputConfigPath
+		if len(ciopConfigs) > 1 {
+			path = branchOutputPath(o.outputConfigPath, ciopConfig.Info.Branch)
+		}
+		if err := writeYAML(path, ciopConfig.Configuration); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write ci-operator config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// branchOutputPath inserts branch before base's extension, so fanning out
+// across branches doesn't make every branch's config overwrite the last.
+func branchOutputPath(base, branch string) string {
+	ext := ""
+	if idx := strings.LastIndex(base, "."); idx != -1 {
+		ext = base[idx:]
+		base = base[:idx]
+	}
+	return fmt.Sprintf("%s-%s%s", base, branch, ext)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/util/diff"
@@ -291,6 +293,85 @@ func TestEditPluginConfig(t *testing.T) {
 	}
 }
 
+func TestPlanProwConfig(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		prowConfig      *prowconfig.Config
+		config          initConfig
+		expectedChanges int
+	}{
+		{
+			name: "queries already exist, no changes planned",
+			config: initConfig{
+				Org:  "org",
+				Repo: "repo",
+			},
+			prowConfig: &prowconfig.Config{
+				ProwConfig: prowconfig.ProwConfig{
+					Tide: prowconfig.Tide{
+						Queries: prowconfig.TideQueries{{
+							Repos: []string{"org/repo"},
+						}},
+					},
+				},
+			},
+			expectedChanges: 0,
+		},
+		{
+			name: "repo not yet onboarded, one change planned",
+			config: initConfig{
+				Org:      "org",
+				Repo:     "repo",
+				Promotes: true,
+			},
+			prowConfig: &prowconfig.Config{
+				ProwConfig: prowconfig.ProwConfig{
+					Tide: prowconfig.Tide{
+						Queries: prowconfig.TideQueries{{
+							Repos: []string{"openshift/ci-tools"},
+						}},
+					},
+				},
+			},
+			expectedChanges: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			before := testCase.prowConfig.Tide.Queries[0].Repos
+			_, changes := planProwConfig(testCase.prowConfig, testCase.config)
+			if len(changes) != testCase.expectedChanges {
+				t.Errorf("%s: expected %d changes, got %d: %v", testCase.name, testCase.expectedChanges, len(changes), changes)
+			}
+			if actual := testCase.prowConfig.Tide.Queries[0].Repos; !reflect.DeepEqual(actual, before) {
+				t.Errorf("%s: planProwConfig mutated the original config: %v", testCase.name, diff.ObjectReflectDiff(actual, before))
+			}
+		})
+	}
+}
+
+func TestPlanPluginConfig(t *testing.T) {
+	config := initConfig{Org: "org", Repo: "repo"}
+	orig := &plugins.Configuration{
+		Plugins: map[string][]string{"openshift": {"approve", "lgtm"}},
+	}
+	before := orig.Plugins["openshift"]
+	planned, changes := planPluginConfig(orig, config)
+	if expected := 3; len(changes) != expected {
+		t.Errorf("expected %d changes, got %d: %v", expected, len(changes), changes)
+	}
+	if _, exists := orig.Plugins["org/repo"]; exists {
+		t.Errorf("planPluginConfig mutated the original plugin config")
+	}
+	if actual := orig.Plugins["openshift"]; !reflect.DeepEqual(actual, before) {
+		t.Errorf("planPluginConfig mutated the original plugin config: %v", diff.ObjectReflectDiff(actual, before))
+	}
+
+	if _, changes := planPluginConfig(planned, config); len(changes) != 0 {
+		t.Errorf("expected no further changes from re-planning an already onboarded repo, got %d: %v", len(changes), changes)
+	}
+}
+
 func strP(str string) *string {
 	return &str
 }
@@ -578,12 +659,393 @@ func TestGenerateCIOperatorConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "non-go runtime skips go-specific fields",
+			config: initConfig{
+				Org:     "org",
+				Repo:    "repo",
+				Branch:  "branch",
+				Runtime: "nodejs:14",
+			},
+			originConfig: &api.PromotionConfiguration{
+				Namespace: "promote",
+				Name:      "version",
+			},
+			expected: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					InputConfiguration: api.InputConfiguration{
+						BuildRootImage: &api.BuildRootImageConfiguration{
+							ImageStreamTagReference: &api.ImageStreamTagReference{
+								Namespace: "openshift",
+								Name:      "release",
+								Tag:       "nodejs-14",
+							},
+						},
+					},
+					Tests: []api.TestStepConfiguration{},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+				Info: ciopconfig.Info{
+					Org:    "org",
+					Repo:   "repo",
+					Branch: "branch",
+				},
+			},
+		},
+		{
+			name: "fully custom build root ref is used as-is",
+			config: initConfig{
+				Org:    "org",
+				Repo:   "repo",
+				Branch: "branch",
+				BuildRootRef: &api.ImageStreamTagReference{
+					Namespace: "some-namespace",
+					Name:      "custom-build-root",
+					Tag:       "latest",
+				},
+			},
+			originConfig: &api.PromotionConfiguration{
+				Namespace: "promote",
+				Name:      "version",
+			},
+			expected: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					InputConfiguration: api.InputConfiguration{
+						BuildRootImage: &api.BuildRootImageConfiguration{
+							ImageStreamTagReference: &api.ImageStreamTagReference{
+								Namespace: "some-namespace",
+								Name:      "custom-build-root",
+								Tag:       "latest",
+							},
+						},
+					},
+					Tests: []api.TestStepConfiguration{},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+				Info: ciopconfig.Info{
+					Org:    "org",
+					Repo:   "repo",
+					Branch: "branch",
+				},
+			},
+		},
+		{
+			name: "promoting with no origin config falls back to empty promotion",
+			config: initConfig{
+				Org:                   "org",
+				Repo:                  "repo",
+				Branch:                "branch",
+				CanonicalGoRepository: "sometimes.com",
+				GoVersion:             "1",
+				Promotes:              true,
+			},
+			originConfig: nil,
+			expected: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					PromotionConfiguration: &api.PromotionConfiguration{},
+					InputConfiguration: api.InputConfiguration{
+						ReleaseTagConfiguration: &api.ReleaseTagConfiguration{},
+						BuildRootImage: &api.BuildRootImageConfiguration{
+							ImageStreamTagReference: &api.ImageStreamTagReference{
+								Namespace: "openshift",
+								Name:      "release",
+								Tag:       "golang-1",
+							},
+						},
+					},
+					CanonicalGoRepository: strP("sometimes.com"),
+					Tests:                 []api.TestStepConfiguration{},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+				Info: ciopconfig.Info{
+					Org:    "org",
+					Repo:   "repo",
+					Branch: "branch",
+				},
+			},
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			if actual, expected := generateCIOperatorConfig(testCase.config, testCase.originConfig), testCase.expected; !reflect.DeepEqual(actual, expected) {
+			if actual, expected := generateCIOperatorConfig(testCase.config, testCase.originConfig), []ciopconfig.DataWithInfo{testCase.expected}; !reflect.DeepEqual(actual, expected) {
 				t.Errorf("%s: got incorrect generated CI Operator config: %v", testCase.name, diff.ObjectReflectDiff(actual, expected))
 			}
 		})
 	}
 }
+
+func TestMigrateCIOperatorConfig(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		existing        ciopconfig.DataWithInfo
+		expected        ciopconfig.DataWithInfo
+		expectedChanges int
+	}{
+		{
+			name: "already canonical config is untouched",
+			existing: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					Tests: []api.TestStepConfiguration{{
+						As: "unit",
+						MultiStageTestConfiguration: &api.MultiStageTestConfiguration{
+							Test: []api.TestStep{{Reference: strP("unit")}},
+						},
+					}},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+			},
+			expected: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					Tests: []api.TestStepConfiguration{{
+						As: "unit",
+						MultiStageTestConfiguration: &api.MultiStageTestConfiguration{
+							Test: []api.TestStep{{Reference: strP("unit")}},
+						},
+					}},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+			},
+			expectedChanges: 0,
+		},
+		{
+			name: "inline container test becomes a step reference",
+			existing: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					Tests: []api.TestStepConfiguration{{
+						As:                         "unit",
+						Commands:                   "make test-unit",
+						ContainerTestConfiguration: &api.ContainerTestConfiguration{From: "src"},
+					}},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+			},
+			expected: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					Tests: []api.TestStepConfiguration{{
+						As: "unit",
+						MultiStageTestConfiguration: &api.MultiStageTestConfiguration{
+							Test: []api.TestStep{{Reference: strP("unit")}},
+						},
+					}},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+			},
+			expectedChanges: 1,
+		},
+		{
+			name: "missing resources default is filled in",
+			existing: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					Tests: []api.TestStepConfiguration{},
+				},
+			},
+			expected: ciopconfig.DataWithInfo{
+				Configuration: api.ReleaseBuildConfiguration{
+					Tests: []api.TestStepConfiguration{},
+					Resources: map[string]api.ResourceRequirements{"*": {
+						Limits:   map[string]string{"memory": "4Gi"},
+						Requests: map[string]string{"memory": "200Mi", "cpu": "100m"},
+					}},
+				},
+			},
+			expectedChanges: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, changes, err := migrateCIOperatorConfig(testCase.existing)
+			if err != nil {
+				t.Fatalf("%s: got unexpected error: %v", testCase.name, err)
+			}
+			if !reflect.DeepEqual(actual, testCase.expected) {
+				t.Errorf("%s: got incorrect migrated CI Operator config: %v", testCase.name, diff.ObjectReflectDiff(actual, testCase.expected))
+			}
+			if len(changes) != testCase.expectedChanges {
+				t.Errorf("%s: expected %d changes, got %d: %v", testCase.name, testCase.expectedChanges, len(changes), changes)
+			}
+		})
+	}
+}
+
+func TestMigrateProwConfig(t *testing.T) {
+	existing := &prowconfig.Config{
+		ProwConfig: prowconfig.ProwConfig{
+			Tide: prowconfig.Tide{
+				Queries: prowconfig.TideQueries{{
+					Repos: []string{"org/repo", "org/repo", "org/other"},
+				}},
+			},
+		},
+	}
+	migrated, changes, err := migrateProwConfig(existing)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	expected := []string{"org/repo", "org/other"}
+	if actual := migrated.Tide.Queries[0].Repos; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got incorrect de-duplicated Tide query: %v", diff.ObjectReflectDiff(actual, expected))
+	}
+	if len(changes) != 1 {
+		t.Errorf("expected 1 change, got %d: %v", len(changes), changes)
+	}
+}
+
+func TestMigrateProwConfigDropsEmptyQuery(t *testing.T) {
+	existing := &prowconfig.Config{
+		ProwConfig: prowconfig.ProwConfig{
+			Tide: prowconfig.Tide{
+				Queries: prowconfig.TideQueries{
+					{Repos: []string{}},
+					{Repos: []string{"org/other"}},
+				},
+			},
+		},
+	}
+	migrated, changes, err := migrateProwConfig(existing)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	expected := prowconfig.TideQueries{{Repos: []string{"org/other"}}}
+	if actual := migrated.Tide.Queries; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got incorrect Tide queries: %v", diff.ObjectReflectDiff(actual, expected))
+	}
+	if len(changes) != 1 {
+		t.Errorf("expected 1 change, got %d: %v", len(changes), changes)
+	}
+}
+
+func TestGenerateCIOperatorConfigMultiBranch(t *testing.T) {
+	config := initConfig{
+		Org:                   "org",
+		Repo:                  "repo",
+		CanonicalGoRepository: "sometimes.com",
+		Branches: []BranchSpec{
+			{
+				Branch:    "master",
+				GoVersion: "1.14",
+				Tests:     []test{{As: "unit", Command: "make test-unit", From: "src"}},
+			},
+			{
+				Branch:             "release-4.5",
+				GoVersion:          "1.13",
+				Promotes:           true,
+				PromotionNamespace: "ocp",
+				PromotionName:      "4.5",
+			},
+		},
+	}
+
+	actual := generateCIOperatorConfig(config, nil)
+	if len(actual) != 2 {
+		t.Fatalf("expected 2 generated configs, got %d", len(actual))
+	}
+	if actual[0].Info.Branch != "master" || actual[1].Info.Branch != "release-4.5" {
+		t.Errorf("got configs for unexpected branches: %v, %v", actual[0].Info.Branch, actual[1].Info.Branch)
+	}
+	if actual[0].Configuration.PromotionConfiguration != nil {
+		t.Errorf("master should not promote, got: %v", actual[0].Configuration.PromotionConfiguration)
+	}
+	if expected := (&api.PromotionConfiguration{Namespace: "ocp", Name: "4.5"}); !reflect.DeepEqual(actual[1].Configuration.PromotionConfiguration, expected) {
+		t.Errorf("release-4.5 promotion config: %v", diff.ObjectReflectDiff(actual[1].Configuration.PromotionConfiguration, expected))
+	}
+
+	// editProwConfig/editPluginConfig are called once regardless of how many
+	// branches were generated, so the org/repo entry must not be duplicated.
+	prowConfig := &prowconfig.Config{
+		ProwConfig: prowconfig.ProwConfig{
+			Tide: prowconfig.Tide{Queries: prowconfig.TideQueries{{Repos: []string{"openshift/ci-tools"}}}},
+		},
+	}
+	editProwConfig(prowConfig, config)
+	if expected := []string{"openshift/ci-tools", "org/repo"}; !reflect.DeepEqual(prowConfig.Tide.Queries[0].Repos, expected) {
+		t.Errorf("got duplicated or missing Tide entry: %v", diff.ObjectReflectDiff(prowConfig.Tide.Queries[0].Repos, expected))
+	}
+
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{}, Lgtm: []plugins.Lgtm{}}
+	editPluginConfig(pluginConfig, config)
+	if len(pluginConfig.Approve) != 1 || len(pluginConfig.Lgtm) != 1 {
+		t.Errorf("expected a single approve/lgtm entry across all branches, got approve=%d lgtm=%d", len(pluginConfig.Approve), len(pluginConfig.Lgtm))
+	}
+}
+
+func TestRunKRMFunction(t *testing.T) {
+	input := `apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  spec:
+    org: org
+    repo: repo
+    branch: branch
+    canonicalGoRepository: sometimes.com
+    goVersion: "1"
+    buildCommands: make
+    testBuildCommands: make tests
+items:
+- apiVersion: prow.k8s.io/v1
+  kind: Config
+  tide:
+    queries:
+    - repos:
+      - openshift/ci-tools
+`
+	var out bytes.Buffer
+	if err := runKRMFunction(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	rendered := out.String()
+	if !strings.Contains(rendered, "org/repo") {
+		t.Errorf("expected rendered ResourceList to mention org/repo, got: %s", rendered)
+	}
+	if !strings.Contains(rendered, "ReleaseBuildConfiguration") {
+		t.Errorf("expected rendered ResourceList to contain a generated ReleaseBuildConfiguration, got: %s", rendered)
+	}
+}
+
+func TestRunKRMFunctionMigrate(t *testing.T) {
+	input := `apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  spec:
+    migrate: true
+items:
+- apiVersion: prow.k8s.io/v1
+  kind: Config
+  tide:
+    queries:
+    - repos:
+      - openshift/ci-tools
+      - openshift/ci-tools
+`
+	var out bytes.Buffer
+	if err := runKRMFunction(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	rendered := out.String()
+	if strings.Count(rendered, "openshift/ci-tools") != 1 {
+		t.Errorf("expected migrate mode to de-duplicate the Tide query repo, got: %s", rendered)
+	}
+	if strings.Contains(rendered, "ReleaseBuildConfiguration") {
+		t.Errorf("migrate mode with no existing ci-operator config item must not generate a new one, got: %s", rendered)
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	coreapi "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/watch"
 	coreclientset "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/openshift/ci-tools/pkg/api"
 	"github.com/openshift/ci-tools/pkg/junit"
@@ -22,6 +29,7 @@ const (
 	multiStageTestLabel     = "ci.openshift.io/multi-stage-test"
 	clusterProfileMountPath = "/var/run/secrets/ci.openshift.io/cluster-profile"
 	secretMountPath         = "/var/run/secrets/ci.openshift.io/multi-stage"
+	workspaceMountPath      = "/var/run/secrets/ci.openshift.io/workspace"
 )
 
 type multiStageTestStep struct {
@@ -32,13 +40,19 @@ type multiStageTestStep struct {
 	releaseLatest   string
 	profile         api.ClusterProfile
 	config          *api.ReleaseBuildConfiguration
+	workspace       *api.TestWorkspace
+	timeout         *meta.Duration
+	gracePeriod     *meta.Duration
+	pullSecrets     []string
 	params          api.Parameters
 	podClient       PodClient
 	secretClient    coreclientset.SecretsGetter
+	pvcClient       coreclientset.PersistentVolumeClaimsGetter
 	artifactDir     string
 	jobSpec         *api.JobSpec
 	pre, test, post []api.LiteralTestStep
 	subTests        []*junit.TestCase
+	podInformer     cache.SharedIndexInformer
 }
 
 func MultiStageTestStep(
@@ -47,11 +61,12 @@ func MultiStageTestStep(
 	params api.Parameters,
 	podClient PodClient,
 	secretClient coreclientset.SecretsGetter,
+	pvcClient coreclientset.PersistentVolumeClaimsGetter,
 	artifactDir string,
 	jobSpec *api.JobSpec,
 	logger *DryLogger,
 ) api.Step {
-	return newMultiStageTestStep(testConfig, config, params, podClient, secretClient, artifactDir, jobSpec, logger)
+	return newMultiStageTestStep(testConfig, config, params, podClient, secretClient, pvcClient, artifactDir, jobSpec, logger)
 }
 
 func newMultiStageTestStep(
@@ -60,6 +75,7 @@ func newMultiStageTestStep(
 	params api.Parameters,
 	podClient PodClient,
 	secretClient coreclientset.SecretsGetter,
+	pvcClient coreclientset.PersistentVolumeClaimsGetter,
 	artifactDir string,
 	jobSpec *api.JobSpec,
 	logger *DryLogger,
@@ -72,9 +88,14 @@ func newMultiStageTestStep(
 		name:         testConfig.As,
 		profile:      testConfig.MultiStageTestConfigurationLiteral.ClusterProfile,
 		config:       config,
+		workspace:    testConfig.MultiStageTestConfigurationLiteral.Workspace,
+		timeout:      testConfig.MultiStageTestConfigurationLiteral.Timeout,
+		gracePeriod:  testConfig.MultiStageTestConfigurationLiteral.GracePeriod,
+		pullSecrets:  testConfig.MultiStageTestConfigurationLiteral.PullSecrets,
 		params:       params,
 		podClient:    podClient,
 		secretClient: secretClient,
+		pvcClient:    pvcClient,
 		artifactDir:  artifactDir,
 		jobSpec:      jobSpec,
 		pre:          testConfig.MultiStageTestConfigurationLiteral.Pre,
@@ -87,6 +108,38 @@ func (s *multiStageTestStep) profileSecretName() string {
 	return s.name + "-cluster-profile"
 }
 
+func (s *multiStageTestStep) workspaceClaimName() string {
+	return s.name + "-workspace"
+}
+
+func (s *multiStageTestStep) createWorkspace() error {
+	log.Printf("Creating multi-stage test workspace %q", s.workspaceClaimName())
+	size, err := resource.ParseQuantity(s.workspace.Size)
+	if err != nil {
+		return fmt.Errorf("could not parse workspace size %q: %v", s.workspace.Size, err)
+	}
+	pvc := coreapi.PersistentVolumeClaim{
+		ObjectMeta: meta.ObjectMeta{Name: s.workspaceClaimName()},
+		Spec: coreapi.PersistentVolumeClaimSpec{
+			AccessModes:      []coreapi.PersistentVolumeAccessMode{coreapi.ReadWriteOnce},
+			StorageClassName: s.workspace.StorageClassName,
+			Resources: coreapi.ResourceRequirements{
+				Requests: coreapi.ResourceList{coreapi.ResourceStorage: size},
+			},
+		},
+	}
+	if s.dry {
+		s.logger.AddObject(pvc.DeepCopyObject())
+		return nil
+	}
+	client := s.pvcClient.PersistentVolumeClaims(s.jobSpec.Namespace)
+	if err := client.Delete(s.workspaceClaimName(), &meta.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("cannot delete workspace PVC %q: %v", s.workspaceClaimName(), err)
+	}
+	_, err = client.Create(&pvc)
+	return err
+}
+
 func (s *multiStageTestStep) Inputs(ctx context.Context, dry bool) (api.InputDefinition, error) {
 	return nil, nil
 }
@@ -106,9 +159,30 @@ func (s *multiStageTestStep) Run(ctx context.Context, dry bool) error {
 			return err
 		}
 	}
+	for _, secret := range s.pullSecrets {
+		if _, err := s.secretClient.Secrets(s.jobSpec.Namespace).Get(secret, meta.GetOptions{}); err != nil {
+			return fmt.Errorf("could not find pull secret %q: %v", secret, err)
+		}
+	}
 	if err := s.createSecret(); err != nil {
 		return fmt.Errorf("failed to create secret: %v", err)
 	}
+	if s.workspace != nil {
+		if err := s.createWorkspace(); err != nil {
+			return fmt.Errorf("failed to create workspace: %v", err)
+		}
+		go func() {
+			<-ctx.Done()
+			log.Printf("cleanup: Deleting workspace PVC %q", s.workspaceClaimName())
+			if !s.dry {
+				client := s.pvcClient.PersistentVolumeClaims(s.jobSpec.Namespace)
+				if err := client.Delete(s.workspaceClaimName(), &meta.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+					log.Printf("failed to delete workspace PVC %q: %v", s.workspaceClaimName(), err)
+				}
+			}
+		}()
+	}
+	s.startPodInformer(ctx)
 	var errs []error
 	if err := s.runSteps(ctx, s.pre, true); err != nil {
 		errs = append(errs, fmt.Errorf("%q pre steps failed: %v", s.name, err))
@@ -148,6 +222,9 @@ func (s *multiStageTestStep) Requires() (ret []api.StepLink) {
 		ret = append(ret, s.params.Links("RELEASE_IMAGE_INITIAL")...)
 		ret = append(ret, s.params.Links("RELEASE_IMAGE_LATEST")...)
 	}
+	if len(s.pullSecrets) != 0 {
+		ret = append(ret, api.PullSecretsLink())
+	}
 	return
 }
 
@@ -180,10 +257,27 @@ func (s *multiStageTestStep) runSteps(ctx context.Context, steps []api.LiteralTe
 	return s.runPods(ctx, pods, shortCircuit)
 }
 
-func (s *multiStageTestStep) generatePods(steps []api.LiteralTestStep) ([]coreapi.Pod, error) {
-	var ret []coreapi.Pod
+// stepPod pairs a generated pod with the literal step it was generated from,
+// so that runPod can consult the step's retry policy.
+type stepPod struct {
+	pod  coreapi.Pod
+	step api.LiteralTestStep
+}
+
+func (s *multiStageTestStep) generatePods(steps []api.LiteralTestStep) ([]stepPod, error) {
+	var ret []stepPod
 	var errs []error
 	for _, step := range steps {
+		if step.Timeout == nil {
+			step.Timeout = s.timeout
+		}
+		if step.GracePeriod == nil {
+			step.GracePeriod = s.gracePeriod
+		}
+		pullSecrets := s.pullSecrets
+		if len(step.PullSecrets) != 0 {
+			pullSecrets = step.PullSecrets
+		}
 		image := step.From
 		if s.config.IsPipelineImage(image) {
 			image = fmt.Sprintf("%s:%s", api.PipelineImageStream, image)
@@ -200,7 +294,14 @@ func (s *multiStageTestStep) generatePods(steps []api.LiteralTestStep) ([]coreap
 			continue
 		}
 		pod.Labels[multiStageTestLabel] = s.name
+		// step.Timeout is enforced by waitForPodOrTimeout terminating the pod
+		// itself, not ActiveDeadlineSeconds: the two would race, and only
+		// waitForPodOrTimeout's path reports the documented "timeout" JUnit
+		// case instead of a generic pod failure.
 		addSecretWrapper(pod)
+		if len(step.Services) != 0 {
+			addServices(pod, step.Services)
+		}
 		container := &pod.Spec.Containers[0]
 		container.Env = append(container.Env, []coreapi.EnvVar{
 			{Name: "NAMESPACE", Value: s.jobSpec.Namespace},
@@ -210,6 +311,9 @@ func (s *multiStageTestStep) generatePods(steps []api.LiteralTestStep) ([]coreap
 		if owner := s.jobSpec.Owner(); owner != nil {
 			pod.OwnerReferences = append(pod.OwnerReferences, *owner)
 		}
+		for _, secret := range pullSecrets {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, coreapi.LocalObjectReference{Name: secret})
+		}
 		if s.profile != "" {
 			addProfile(s.profileSecretName(), s.profile, pod)
 			container.Env = append(container.Env, []coreapi.EnvVar{
@@ -219,7 +323,10 @@ func (s *multiStageTestStep) generatePods(steps []api.LiteralTestStep) ([]coreap
 			}...)
 		}
 		addSecret(s.name, pod)
-		ret = append(ret, *pod)
+		if s.workspace != nil {
+			addWorkspace(s.workspaceClaimName(), pod)
+		}
+		ret = append(ret, stepPod{pod: *pod, step: step})
 	}
 	return ret, utilerrors.NewAggregate(errs)
 }
@@ -249,6 +356,50 @@ func addSecretWrapper(pod *coreapi.Pod) {
 	container.VolumeMounts = append(container.VolumeMounts, mount)
 }
 
+// addServices appends one container per requested service to pod (after the
+// main container, which callers rely on remaining at index 0) and wraps the
+// main container's entrypoint with a small wait-for-services binary so it
+// doesn't start until every service's readiness endpoint responds.
+func addServices(pod *coreapi.Pod, services []api.ServiceContainer) {
+	for _, service := range services {
+		pod.Spec.Containers = append(pod.Spec.Containers, coreapi.Container{
+			Name:           service.Name,
+			Image:          service.Image,
+			Command:        service.Command,
+			Env:            service.Env,
+			ReadinessProbe: service.ReadinessProbe,
+		})
+	}
+
+	volume := "wait-for-services"
+	dir := "/tmp/wait-for-services"
+	bin := filepath.Join(dir, "wait-for-services")
+	pod.Spec.Volumes = append(pod.Spec.Volumes, coreapi.Volume{
+		Name: volume,
+		VolumeSource: coreapi.VolumeSource{
+			EmptyDir: &coreapi.EmptyDirVolumeSource{},
+		},
+	})
+	mount := coreapi.VolumeMount{Name: volume, MountPath: dir}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, coreapi.Container{
+		Image:                    "registry.svc.ci.openshift.org/ci/wait-for-services:latest",
+		Name:                     "cp-wait-for-services",
+		Command:                  []string{"cp"},
+		Args:                     []string{"/bin/wait-for-services", bin},
+		VolumeMounts:             []coreapi.VolumeMount{mount},
+		TerminationMessagePolicy: coreapi.TerminationMessageFallbackToLogsOnError,
+	})
+
+	var waitArgs []string
+	for _, service := range services {
+		waitArgs = append(waitArgs, fmt.Sprintf("--service=%s", service.Name))
+	}
+	container := &pod.Spec.Containers[0]
+	container.Args = append(append(waitArgs, "--"), append(container.Command, container.Args...)...)
+	container.Command = []string{bin}
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+}
+
 func addSecret(secret string, pod *coreapi.Pod) {
 	pod.Spec.Volumes = append(pod.Spec.Volumes, coreapi.Volume{
 		Name: secret,
@@ -262,6 +413,25 @@ func addSecret(secret string, pod *coreapi.Pod) {
 	})
 }
 
+func addWorkspace(claim string, pod *coreapi.Pod) {
+	volumeName := "workspace"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, coreapi.Volume{
+		Name: volumeName,
+		VolumeSource: coreapi.VolumeSource{
+			PersistentVolumeClaim: &coreapi.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		},
+	})
+	container := &pod.Spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, coreapi.VolumeMount{
+		Name:      volumeName,
+		MountPath: workspaceMountPath,
+	})
+	container.Env = append(container.Env, coreapi.EnvVar{
+		Name:  "WORKSPACE_DIR",
+		Value: workspaceMountPath,
+	})
+}
+
 func addProfile(name string, profile api.ClusterProfile, pod *coreapi.Pod) {
 	volumeName := "cluster-profile"
 	pod.Spec.Volumes = append(pod.Spec.Volumes, coreapi.Volume{
@@ -283,7 +453,109 @@ func addProfile(name string, profile api.ClusterProfile, pod *coreapi.Pod) {
 	})
 }
 
-func (s *multiStageTestStep) runPods(ctx context.Context, pods []coreapi.Pod, shortCircuit bool) error {
+// startPodInformer starts a pod informer scoped to the job namespace and
+// filtered to this test's pods, so pod completion can be resolved from the
+// informer's cache instead of each wait call polling the API server. The
+// informer is stopped when ctx is cancelled.
+func (s *multiStageTestStep) startPodInformer(ctx context.Context) {
+	selector := fields.Set{multiStageTestLabel: s.name}.AsSelector().String()
+	client := s.podClient.Pods(s.jobSpec.Namespace)
+	s.podInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options meta.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = selector
+				return client.List(options)
+			},
+			WatchFunc: func(options meta.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = selector
+				return client.Watch(options)
+			},
+		},
+		&coreapi.Pod{},
+		0,
+		cache.Indexers{},
+	)
+	stop := make(chan struct{})
+	go s.podInformer.Run(stop)
+	go func() {
+		<-ctx.Done()
+		close(stop)
+	}()
+	cache.WaitForCacheSync(stop, s.podInformer.HasSynced)
+}
+
+// podFromCache returns the last observed state of a pod from the informer's
+// store, avoiding an API call when only a cached read is needed.
+func (s *multiStageTestStep) podFromCache(name string) (*coreapi.Pod, bool) {
+	if s.podInformer == nil {
+		return nil, false
+	}
+	obj, ok, err := s.podInformer.GetStore().GetByKey(s.jobSpec.Namespace + "/" + name)
+	if err != nil || !ok {
+		return nil, false
+	}
+	pod, ok := obj.(*coreapi.Pod)
+	return pod, ok
+}
+
+// waitForPod blocks until the named pod reaches a terminal phase, the same
+// contract as waitForPodCompletion, but resolves the wait from the shared
+// pod informer's cache rather than polling the API server directly. It
+// falls back to waitForPodCompletion if the informer hasn't been started
+// (e.g. in tests that construct a multiStageTestStep without calling Run).
+func (s *multiStageTestStep) waitForPod(ctx context.Context, name string, notifier *TestCaseNotifier) error {
+	if s.podInformer == nil {
+		return waitForPodCompletion(s.podClient.Pods(s.jobSpec.Namespace), name, notifier, false)
+	}
+	done := make(chan error, 1)
+	var once sync.Once
+	report := func(pod *coreapi.Pod) {
+		if pod.Name != name {
+			return
+		}
+		// waitForPodCompletion notifies on every observed pod update, not
+		// just on completion, so the notifier can track each container's
+		// state as it transitions; do the same here so SubTests() still
+		// has per-container results to report when the informer path is
+		// used instead.
+		if notifier != nil {
+			notifier.Notify(pod)
+		}
+		switch pod.Status.Phase {
+		case coreapi.PodSucceeded:
+			once.Do(func() { done <- nil })
+		case coreapi.PodFailed:
+			once.Do(func() {
+				done <- fmt.Errorf("pod %s/%s failed (%s): %s", s.jobSpec.Namespace, name, pod.Status.Reason, pod.Status.Message)
+			})
+		default:
+			// ImagePullBackOff/ErrImagePull never make the pod terminal on
+			// their own, so without this the retry-on-infra-failure path
+			// would never trigger and, absent a step.Timeout, waitForPod
+			// would block until the parent ctx is cancelled.
+			if reason := waitingInfraFailureReason(pod); reason != "" {
+				once.Do(func() {
+					done <- fmt.Errorf("pod %s/%s is waiting: %s", s.jobSpec.Namespace, name, reason)
+				})
+			}
+		}
+	}
+	s.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { report(obj.(*coreapi.Pod)) },
+		UpdateFunc: func(_, obj interface{}) { report(obj.(*coreapi.Pod)) },
+	})
+	if pod, ok := s.podFromCache(name); ok {
+		report(pod)
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *multiStageTestStep) runPods(ctx context.Context, pods []stepPod, shortCircuit bool) error {
 	go func() {
 		<-ctx.Done()
 		log.Printf("cleanup: Deleting pods with label %s=%s", multiStageTestLabel, s.name)
@@ -294,7 +566,8 @@ func (s *multiStageTestStep) runPods(ctx context.Context, pods []coreapi.Pod, sh
 		}
 	}()
 	var errs []error
-	for _, pod := range pods {
+	for _, sp := range pods {
+		pod := sp.pod
 		log.Printf("Executing %q", pod.Name)
 		var notifier ContainerNotifier = NopNotifier
 		for _, c := range pod.Spec.Containers {
@@ -306,7 +579,7 @@ func (s *multiStageTestStep) runPods(ctx context.Context, pods []coreapi.Pod, sh
 				break
 			}
 		}
-		if err := s.runPod(ctx, &pod, NewTestCaseNotifier(notifier)); err != nil {
+		if err := s.runPod(ctx, &pod, NewTestCaseNotifier(notifier), sp.step); err != nil {
 			errs = append(errs, err)
 			if shortCircuit {
 				break
@@ -316,7 +589,122 @@ func (s *multiStageTestStep) runPods(ctx context.Context, pods []coreapi.Pod, sh
 	return utilerrors.NewAggregate(errs)
 }
 
-func (s *multiStageTestStep) runPod(ctx context.Context, pod *coreapi.Pod, notifier *TestCaseNotifier) error {
+// retryBackoff returns the maximum number of attempts and the initial and
+// maximum backoff durations configured for a step, falling back to the
+// defaults used for every multi-stage pod.
+func retryBackoff(step api.LiteralTestStep) (int, time.Duration, time.Duration) {
+	maxAttempts := 1
+	if step.MaxAttempts != nil && *step.MaxAttempts > 0 {
+		maxAttempts = *step.MaxAttempts
+	}
+	initial := 2 * time.Second
+	if step.BackoffInitial != nil {
+		initial = step.BackoffInitial.Duration
+	}
+	max := 30 * time.Second
+	if step.BackoffMax != nil {
+		max = step.BackoffMax.Duration
+	}
+	return maxAttempts, initial, max
+}
+
+// jitter returns d adjusted by up to +/-25%, so that many steps retrying in
+// lockstep after the same kind of infrastructure failure don't all hammer
+// the API server again at exactly the same moment.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	delta := time.Duration(rand.Int63n(int64(d)/2+1)) - d/4
+	return d + delta
+}
+
+// waitingInfraFailureReason returns the Waiting reason of the first
+// container stuck in a state that looks like a transient infrastructure
+// issue rather than normal pod startup, or "" if none are. Unlike a pod
+// failure, these reasons never resolve into a terminal pod phase on their
+// own, so waitForPod has to detect them directly instead of waiting for
+// PodFailed.
+func waitingInfraFailureReason(pod *coreapi.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ImagePullBackOff", "ErrImagePull":
+			return status.State.Waiting.Reason
+		}
+	}
+	return ""
+}
+
+// isInfraFailure reports whether a pod failure looks like a transient
+// infrastructure issue (image pull problems, node churn, API server hiccups)
+// as opposed to a non-zero exit from the user's own command, which must
+// never be retried.
+func isInfraFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.IsTimeout(err) || errors.IsServerTimeout(err) {
+		return true
+	}
+	for _, reason := range []string{"ImagePullBackOff", "ErrImagePull", "NodeLost", "Evicted", "ContainerCreating"} {
+		if strings.Contains(err.Error(), reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForPodOrTimeout waits for the pod to complete, enforcing step.Timeout
+// if one is set. A timeout is treated as a normal test failure: the pod is
+// terminated (allowing up to step.GracePeriod before the kubelet force-kills
+// it) and a junit.TestCase is recorded, but the error returned never wraps
+// ctx.Err(), so callers must not mistake it for cancellation of the parent
+// job and can safely continue on to post steps.
+func (s *multiStageTestStep) waitForPodOrTimeout(ctx context.Context, pod *coreapi.Pod, notifier *TestCaseNotifier, step api.LiteralTestStep) error {
+	if step.Timeout == nil {
+		return s.waitForPod(ctx, pod.Name, notifier)
+	}
+	deadline, cancel := context.WithTimeout(ctx, step.Timeout.Duration)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.waitForPod(deadline, pod.Name, notifier)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-deadline.Done():
+		if deadline.Err() != context.DeadlineExceeded {
+			// the parent context was cancelled, not our own timeout
+			return ctx.Err()
+		}
+		s.terminatePod(pod, step.GracePeriod)
+		err := fmt.Errorf("%q pod %q timed out after %s", s.name, pod.Name, step.Timeout.Duration)
+		s.subTests = append(s.subTests, &junit.TestCase{
+			Name:          fmt.Sprintf("%s - %s", s.Description(), pod.Name),
+			FailureOutput: &junit.FailureOutput{Message: "timeout", Output: err.Error()},
+		})
+		return err
+	}
+}
+
+// terminatePod asks the kubelet to gracefully stop the pod, giving it up to
+// grace (or the cluster default if unset) before it is force-killed.
+func (s *multiStageTestStep) terminatePod(pod *coreapi.Pod, grace *meta.Duration) {
+	opts := &meta.DeleteOptions{}
+	if grace != nil {
+		seconds := int64(grace.Duration.Seconds())
+		opts.GracePeriodSeconds = &seconds
+	}
+	if err := s.podClient.Pods(s.jobSpec.Namespace).Delete(pod.Name, opts); err != nil && !errors.IsNotFound(err) {
+		log.Printf("failed to terminate timed-out pod %q: %v", pod.Name, err)
+	}
+}
+
+func (s *multiStageTestStep) runPod(ctx context.Context, pod *coreapi.Pod, notifier *TestCaseNotifier, step api.LiteralTestStep) error {
 	if s.dry {
 		s.logger.AddObject(pod.DeepCopyObject())
 		return nil
@@ -325,11 +713,35 @@ func (s *multiStageTestStep) runPod(ctx context.Context, pod *coreapi.Pod, notif
 		<-ctx.Done()
 		notifier.Cancel()
 	}()
-	if _, err := createOrRestartPod(s.podClient.Pods(s.jobSpec.Namespace), pod); err != nil {
-		return fmt.Errorf("failed to create or restart %q pod: %v", pod.Name, err)
+	maxAttempts, backoff, backoffMax := retryBackoff(step)
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if _, createErr := createOrRestartPod(s.podClient.Pods(s.jobSpec.Namespace), pod); createErr != nil {
+			err = fmt.Errorf("failed to create or restart %q pod: %v", pod.Name, createErr)
+		} else if waitErr := s.waitForPodOrTimeout(ctx, pod, notifier, step); waitErr != nil {
+			err = waitErr
+		} else {
+			err = nil
+		}
+		if err == nil || attempt == maxAttempts || !isInfraFailure(err) {
+			break
+		}
+		s.subTests = append(s.subTests, &junit.TestCase{
+			Name:          fmt.Sprintf("%s - %s - attempt %d", s.Description(), pod.Name, attempt),
+			FailureOutput: &junit.FailureOutput{Output: err.Error()},
+		})
+		log.Printf("Retrying %q after infrastructure failure (attempt %d/%d): %v", pod.Name, attempt, maxAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitter(backoff)):
+		}
+		if backoff *= 2; backoff > backoffMax {
+			backoff = backoffMax
+		}
 	}
-	if err := waitForPodCompletion(s.podClient.Pods(s.jobSpec.Namespace), pod.Name, notifier, false); err != nil {
-		return fmt.Errorf("%q pod %q failed: %v", s.name, pod.Name, err)
+	if err != nil {
+		return err
 	}
 	s.subTests = append(s.subTests, notifier.SubTests(fmt.Sprintf("%s - %s ", s.Description(), pod.Name))...)
 	return nil